@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// blacklistedTokensCollection stores the jti of access tokens that have
+// been revoked via /logout, so AuthRequired can reject them even though
+// they haven't expired yet.
+var blacklistedTokensCollection *mongo.Collection
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
+
+// jwtSecret is populated by initAuth, not at package-init time: package
+// vars initialize before main() calls godotenv.Load(), so reading
+// JWT_SECRET here directly would only ever see the value from a real
+// environment variable, never one set via .env.
+var jwtSecret []byte
+
+// initAuth loads JWT_SECRET once .env has been read and refuses to
+// start the server with an empty secret, mirroring the MONGO_URI check.
+func initAuth() {
+	jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+	if len(jwtSecret) == 0 {
+		log.Fatal("❌ JWT_SECRET not set")
+	}
+}
+
+// claims mirrors what we stash on the gin context for downstream handlers.
+type claims struct {
+	UID  string `json:"uid"`
+	Role string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+func hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func signToken(uid, role string, ttl time.Duration) (string, string, error) {
+	jti := primitive.NewObjectID().Hex()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		UID:  uid,
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	signed, err := token.SignedString(jwtSecret)
+	return signed, jti, err
+}
+
+func parseToken(tokenString string) (*claims, error) {
+	parsed, err := jwt.ParseWithClaims(tokenString, &claims{}, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !parsed.Valid {
+		return nil, errors.New("invalid token")
+	}
+	c, ok := parsed.Claims.(*claims)
+	if !ok {
+		return nil, errors.New("invalid claims")
+	}
+	if c.ID != "" {
+		count, err := blacklistedTokensCollection.CountDocuments(context.Background(), bson.M{"jti": c.ID})
+		if err == nil && count > 0 {
+			return nil, errors.New("token revoked")
+		}
+	}
+	return c, nil
+}
+
+// AuthRequired parses the Authorization header and stashes the logged-in
+// user's claims on the context under "user" (read back with c.Get("user"),
+// as RoleRequired does). Browser clients that never got a bearer
+// token (no REDIS_URL means sessions aren't wired up, so this is a no-op
+// for them) fall back to the Redis-backed session loginHandler populates.
+func AuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			if user := sessionUser(c); user != nil {
+				c.Set("user", user)
+				c.Next()
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Missing bearer token"})
+			return
+		}
+		parsed, err := parseToken(strings.TrimPrefix(header, "Bearer "))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+		c.Set("user", parsed)
+		c.Next()
+	}
+}
+
+// sessionUser reads the uid/role loginHandler stashed in the session
+// cookie for browser clients that can't hold a JWT. Returns nil if
+// sessions aren't wired up (no REDIS_URL, so sessions.Sessions never ran)
+// or nothing's logged in.
+func sessionUser(c *gin.Context) *claims {
+	raw, exists := c.Get(sessions.DefaultKey)
+	if !exists {
+		return nil
+	}
+	session, ok := raw.(sessions.Session)
+	if !ok {
+		return nil
+	}
+	uid, _ := session.Get("uid").(string)
+	if uid == "" {
+		return nil
+	}
+	role, _ := session.Get("role").(string)
+	return &claims{UID: uid, Role: role}
+}
+
+// RoleRequired must run after AuthRequired; it rejects requests whose
+// user role isn't in the allowed set.
+func RoleRequired(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("user")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Not authenticated"})
+			return
+		}
+		user := value.(*claims)
+		for _, role := range roles {
+			if user.Role == role {
+				c.Next()
+				return
+			}
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient role"})
+	}
+}
+
+// contextUser is the subset of a user document handlers need once a
+// bearer token (if any) has been resolved to its owner.
+type contextUser struct {
+	Role   string
+	Groups []string
+}
+
+// optionalUser resolves the caller's role/groups when a valid bearer
+// token is present, without rejecting anonymous requests the way
+// AuthRequired does. Endpoints like getModulesHandler use it to widen
+// what's returned for authenticated, non-admin callers.
+func optionalUser(c *gin.Context) *contextUser {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil
+	}
+	parsed, err := parseToken(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return nil
+	}
+	var doc bson.M
+	if err := usersCollection.FindOne(context.Background(), bson.M{"uid": parsed.UID}).Decode(&doc); err != nil {
+		return &contextUser{Role: parsed.Role}
+	}
+	var groups []string
+	if raw, ok := doc["groups"].(bson.A); ok {
+		for _, g := range raw {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	return &contextUser{Role: parsed.Role, Groups: groups}
+}
+
+func loginHandler(c *gin.Context) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	var user bson.M
+	if err := usersCollection.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&user); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	hashed, _ := user["password"].(string)
+	if err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	uid, _ := user["uid"].(string)
+	role, _ := user["role"].(string)
+
+	accessToken, _, err := signToken(uid, role, accessTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+	refreshToken, _, err := signToken(uid, role, refreshTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+
+	// Also populate the session, if one's wired up, so browser clients
+	// that never store the bearer token still authenticate via cookie.
+	if raw, exists := c.Get(sessions.DefaultKey); exists {
+		if session, ok := raw.(sessions.Session); ok {
+			session.Set("uid", uid)
+			session.Set("role", role)
+			if err := session.Save(); err != nil {
+				log.Println("⚠️ Failed to save session:", err)
+			}
+		}
+	}
+
+	delete(user, "password")
+	c.JSON(http.StatusOK, gin.H{
+		"user":         user,
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+	})
+}
+
+func refreshTokenHandler(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	parsed, err := parseToken(req.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	accessToken, _, err := signToken(parsed.UID, parsed.Role, accessTokenTTL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"accessToken": accessToken})
+}
+
+// logoutHandler blacklists the presented access token's jti so it can no
+// longer pass AuthRequired, even though it hasn't expired yet, and clears
+// the session for clients that logged in via cookie instead.
+func logoutHandler(c *gin.Context) {
+	if raw, exists := c.Get(sessions.DefaultKey); exists {
+		if session, ok := raw.(sessions.Session); ok {
+			session.Clear()
+			session.Save()
+		}
+	}
+
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+		return
+	}
+	parsed, err := parseToken(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return
+	}
+	_, err = blacklistedTokensCollection.InsertOne(context.Background(), bson.M{
+		"jti":       parsed.ID,
+		"expiresAt": parsed.ExpiresAt.Time,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
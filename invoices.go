@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	qrcode "github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// lnNodeURL/lnMacaroon configure the Lightning node backend (lnbits,
+// LND REST, or c-lightning-charge) that issues and settles invoices.
+// These are populated by initInvoices, not at package-init time:
+// package vars initialize before main() calls godotenv.Load(), so
+// reading them here directly would never see a value set via .env.
+var (
+	lnNodeURL       string
+	lnMacaroon      string
+	lnWebhookSecret string
+	lnPollInterval  = 10 * time.Second
+)
+
+func initInvoices() {
+	lnNodeURL = os.Getenv("LN_NODE_URL")
+	lnMacaroon = os.Getenv("LN_MACAROON")
+	lnWebhookSecret = os.Getenv("LN_WEBHOOK_SECRET")
+}
+
+type lnInvoice struct {
+	PaymentHash string `json:"payment_hash"`
+	Bolt11      string `json:"payment_request"`
+}
+
+// createInvoice asks the configured Lightning node for a BOLT11 invoice
+// covering the given tuition amount (in cents/sats, as configured on the
+// node) and returns its payment hash and bolt11 string.
+func createInvoice(amountSats int64, memo string) (*lnInvoice, error) {
+	if lnNodeURL == "" {
+		return nil, fmt.Errorf("LN_NODE_URL not configured")
+	}
+	body, _ := json.Marshal(bson.M{"amount": amountSats, "memo": memo})
+	req, err := http.NewRequest(http.MethodPost, lnNodeURL+"/v1/invoices", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Grpc-Metadata-macaroon", lnMacaroon)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("node returned %d: %s", resp.StatusCode, data)
+	}
+
+	var invoice lnInvoice
+	if err := json.NewDecoder(resp.Body).Decode(&invoice); err != nil {
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// addInvoiceToPayment generates an invoice for an existing payment
+// document and stores the payment_hash/bolt11/status on it.
+func addInvoiceToPayment(paymentID primitive.ObjectID, amountSats int64, memo string) error {
+	invoice, err := createInvoice(amountSats, memo)
+	if err != nil {
+		return err
+	}
+	update := bson.M{"$set": bson.M{
+		"paymentHash":   invoice.PaymentHash,
+		"bolt11":        invoice.Bolt11,
+		"invoiceStatus": "pending",
+	}}
+	_, err = paymentCollection.UpdateOne(context.Background(), bson.M{"_id": paymentID}, update)
+	return err
+}
+
+// invoiceHandler returns the stored invoice for a payment along with a
+// data: URL encoded QR code so clients can render it without a QR library.
+func invoiceHandler(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	var payment bson.M
+	if err := paymentCollection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&payment); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+	bolt11, _ := payment["bolt11"].(string)
+	if bolt11 == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No invoice for this payment"})
+		return
+	}
+
+	png, err := qrcode.Encode(bolt11, qrcode.Medium, 256)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"paymentHash":   payment["paymentHash"],
+		"bolt11":        bolt11,
+		"invoiceStatus": payment["invoiceStatus"],
+		"qrDataUrl":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// invoiceWebhookHandler accepts the node's HMAC-signed settlement
+// callback as an alternative to pollInvoiceSettlements.
+func invoiceWebhookHandler(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+
+	if lnWebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(lnWebhookSecret))
+		mac.Write(body)
+		expected := hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(expected), []byte(c.GetHeader("X-Signature"))) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid webhook signature"})
+			return
+		}
+	}
+
+	var payload struct {
+		PaymentHash string `json:"payment_hash"`
+		Status      string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	if payload.Status == "paid" || payload.Status == "settled" {
+		if err := settleInvoice(payload.PaymentHash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to settle invoice"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+// settleInvoice marks the payment's invoice paid, flips the current
+// month to "Paid", and broadcasts the update the same way
+// updatePaymentMonthHandler does.
+func settleInvoice(paymentHash string) error {
+	var payment bson.M
+	if err := paymentCollection.FindOne(context.Background(), bson.M{"paymentHash": paymentHash}).Decode(&payment); err != nil {
+		return err
+	}
+
+	month := currentTuitionMonth()
+	update := bson.M{"$set": bson.M{
+		"invoiceStatus":    "paid",
+		"monthly." + month: "Paid",
+	}}
+	if _, err := paymentCollection.UpdateOne(context.Background(), bson.M{"paymentHash": paymentHash}, update); err != nil {
+		return err
+	}
+
+	var updated bson.M
+	if err := paymentCollection.FindOne(context.Background(), bson.M{"paymentHash": paymentHash}).Decode(&updated); err != nil {
+		return err
+	}
+	publishPaymentUpdate(updated)
+	return nil
+}
+
+// currentTuitionMonth matches the lowercase month keys addPaymentHandler
+// seeds the "monthly" map with (e.g. "july"), not time.Month's
+// capitalized String().
+func currentTuitionMonth() string {
+	return strings.ToLower(time.Now().Month().String())
+}
+
+// pollInvoiceSettlements periodically checks pending invoices against
+// the node and settles any that have been paid. It's the only
+// background settlement path (there is no subscription to node invoice
+// updates); invoiceWebhookHandler is the push-based alternative.
+func pollInvoiceSettlements() {
+	ticker := time.NewTicker(lnPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cursor, err := paymentCollection.Find(context.Background(), bson.M{"invoiceStatus": "pending"})
+		if err != nil {
+			log.Println("⚠️ Failed to list pending invoices:", err)
+			continue
+		}
+		var pending []bson.M
+		if err := cursor.All(context.Background(), &pending); err != nil {
+			continue
+		}
+		for _, payment := range pending {
+			hash, _ := payment["paymentHash"].(string)
+			if hash == "" || lnNodeURL == "" {
+				continue
+			}
+			if paid, err := checkInvoicePaid(hash); err == nil && paid {
+				if err := settleInvoice(hash); err != nil {
+					log.Println("⚠️ Failed to settle invoice:", err)
+				}
+			}
+		}
+	}
+}
+
+func checkInvoicePaid(paymentHash string) (bool, error) {
+	req, err := http.NewRequest(http.MethodGet, lnNodeURL+"/v1/invoice/"+paymentHash, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Grpc-Metadata-macaroon", lnMacaroon)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Settled bool `json:"settled"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Settled, nil
+}
@@ -4,16 +4,23 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-contrib/cors"
+	"github.com/gin-contrib/sessions"
+	redisstore "github.com/gin-contrib/sessions/redis"
 	"github.com/gin-gonic/gin"
 	socketio "github.com/googollee/go-socket.io"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+	"github.com/russross/blackfriday/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -29,22 +36,33 @@ var (
 	gradesCollection   *mongo.Collection
 	server             *socketio.Server
 	connectedUsers     = make(map[string]socketio.Conn)
+	connectedUsersMu   sync.RWMutex
 	mongoClient        *mongo.Client
 )
 
 var BACKEND_URL = "https://publicbackend-production.up.railway.app"
 
 type Module struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
-	Title     string             `bson:"title" json:"title"`
-	FileUrl   string             `bson:"fileUrl" json:"fileUrl"`
-	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"_id"`
+	Title             string             `bson:"title" json:"title"`
+	FileUrl           string             `bson:"fileUrl" json:"fileUrl"`
+	StorageKey        string             `bson:"storageKey" json:"storageKey"`
+	Promo             int                `bson:"promo" json:"promo"`
+	Group             string             `bson:"group" json:"group"`
+	StartAvailability time.Time          `bson:"startAvailability" json:"startAvailability"`
+	EndAvailability   time.Time          `bson:"endAvailability" json:"endAvailability"`
+	Shown             bool               `bson:"shown" json:"shown"`
+	DescriptionRaw    string             `bson:"descriptionRaw" json:"descriptionRaw"`
+	DescriptionHTML   string             `bson:"descriptionHtml" json:"descriptionHtml"`
+	CreatedAt         time.Time          `bson:"createdAt" json:"createdAt"`
 }
 
 func main() {
 	if err := godotenv.Load(); err != nil {
 		log.Println("⚠️ No .env file found")
 	}
+	initAuth()
+	initInvoices()
 	mongoURI := os.Getenv("MONGO_URI")
 	if mongoURI == "" {
 		log.Fatal("❌ MONGO_URI not set")
@@ -69,6 +87,14 @@ func main() {
 	paymentCollection = db.Collection("payments")
 	modulesCollection = db.Collection("modules")
 	gradesCollection = db.Collection("grades")
+	blacklistedTokensCollection = db.Collection("blacklisted_tokens")
+
+	initRealtime()
+	initStorage()
+
+	if err := ensureIndexes(context.Background()); err != nil {
+		log.Println("⚠️ Failed to create indexes:", err)
+	}
 
 	server = socketio.NewServer(nil)
 
@@ -79,24 +105,32 @@ func main() {
 
 	server.OnEvent("/", "register", func(s socketio.Conn, uid string) {
 		if uid != "" {
-			connectedUsers[uid] = s
+			setConnectedUser(uid, s)
+			registerPresence(uid)
 			log.Println("Registered UID:", uid, "with socket:", s.ID())
 		}
 	})
 
+	server.OnEvent("/", "heartbeat", func(s socketio.Conn, uid string) {
+		if uid != "" {
+			refreshPresence(uid)
+		}
+	})
+
 	server.OnDisconnect("/", func(s socketio.Conn, reason string) {
 		log.Println("❌ WebSocket disconnected:", reason)
-		for uid, conn := range connectedUsers {
-			if conn.ID() == s.ID() {
-				delete(connectedUsers, uid)
-				break
-			}
+		if uid, ok := deleteConnectedUserBySocketID(s.ID()); ok {
+			clearPresence(uid)
 		}
 	})
 
 	go server.Serve()
 	defer server.Close()
 
+	if lnNodeURL != "" {
+		go pollInvoiceSettlements()
+	}
+
 	r := gin.Default()
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:1420", "https://publicbackend-production.up.railway.app"},
@@ -107,39 +141,65 @@ func main() {
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Sessions ride the same Redis instance as the realtime fan-out, so
+	// they're only wired up when REDIS_URL is actually set (mirroring
+	// initRealtime's fallback instead of crashing a single-instance deploy).
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		opts, err := redis.ParseURL(redisURL)
+		if err != nil {
+			log.Fatal("❌ Invalid REDIS_URL:", err)
+		}
+		store, err := redisstore.NewStoreWithDB(10, "tcp", opts.Addr, opts.Password, strconv.Itoa(opts.DB), []byte(os.Getenv("SESSION_SECRET")))
+		if err != nil {
+			log.Fatal("❌ Redis session store failed:", err)
+		}
+		r.Use(sessions.Sessions("admin1_session", store))
+	}
+
 	r.GET("/socket.io/*any", gin.WrapH(server))
 	r.POST("/socket.io/*any", gin.WrapH(server))
 
+	// ========== AUTH ==========
+	r.POST("/login", loginHandler)
+	r.POST("/refreshToken", refreshTokenHandler)
+	r.POST("/logout", AuthRequired(), logoutHandler)
+
 	// ========== USER & POST ==========
 	r.POST("/createUser", createUserHandler)
-	r.POST("/login", loginHandler)
 	r.POST("/user", getUserProfileHandler)
-	r.GET("/getUsers", getUsersHandler)
-	r.DELETE("/deleteUser", deleteUserHandler)
-	r.GET("/getPosts", getPostsHandler)
-	r.POST("/uploadPost", uploadPostHandler)
-	r.DELETE("/deletePost", deletePostHandler)
+	r.GET("/getUsers", RequireValidLimit(), getUsersHandler)
+	r.DELETE("/deleteUser", AuthRequired(), RoleRequired("admin"), deleteUserHandler)
+	r.GET("/getPosts", RequireValidLimit(), getPostsHandler)
+	r.POST("/uploadPost", AuthRequired(), RoleRequired("admin", "teacher"), uploadPostHandler)
+	r.DELETE("/deletePost", AuthRequired(), RoleRequired("admin", "teacher"), deletePostHandler)
 
 	// ========== CHAT ==========
-	r.GET("/getMessages", getMessagesHandler)
+	r.GET("/getMessages", RequireValidLimit(), getMessagesHandler)
+	r.GET("/getMessages/cursor", messagesCursorHandler)
 	r.POST("/sendMessage", sendMessageHandler)
 
 	// ========== PAYMENTS ==========
 	r.POST("/addPayment", addPaymentHandler)
-	r.GET("/getPayments", getPaymentsHandler)
-	r.PATCH("/updatePaymentMonth", updatePaymentMonthHandler)
+	r.GET("/getPayments", RequireValidLimit(), getPaymentsHandler)
+	r.PATCH("/updatePaymentMonth", AuthRequired(), RoleRequired("admin"), updatePaymentMonthHandler)
+	r.GET("/invoice/:id", invoiceHandler)
+	r.POST("/invoice/webhook", invoiceWebhookHandler)
 
 	// ========== MODULES ==========
-	r.POST("/uploadModule", uploadModuleHandler)
-	r.GET("/getModules", getModulesHandler)
-	r.DELETE("/deleteModule", deleteModuleHandler)
+	r.POST("/uploadModule", AuthRequired(), RoleRequired("admin", "teacher"), uploadModuleHandler)
+	r.POST("/uploads", AuthRequired(), RoleRequired("admin", "teacher"), createUploadHandler)
+	r.PATCH("/uploads/:id", AuthRequired(), RoleRequired("admin", "teacher"), appendUploadHandler)
+	r.HEAD("/uploads/:id", AuthRequired(), RoleRequired("admin", "teacher"), headUploadHandler)
+	r.GET("/getModules", RequireValidLimit(), getModulesHandler)
+	r.PATCH("/modules/:id", AuthRequired(), RoleRequired("admin", "teacher"), updateModuleHandler)
+	r.DELETE("/deleteModule", AuthRequired(), RoleRequired("admin", "teacher"), deleteModuleHandler)
 
 	// ========== GRADES ==========
-	r.POST("/uploadGrade", uploadGradeHandler)
-	r.GET("/getGrades", getGradesHandler)
-	r.DELETE("/deleteGrade", deleteGradeHandler)
+	r.POST("/uploadGrade", AuthRequired(), RoleRequired("admin", "teacher"), uploadGradeHandler)
+	r.GET("/getGrades", RequireValidLimit(), getGradesHandler)
+	r.DELETE("/deleteGrade", AuthRequired(), RoleRequired("admin", "teacher"), deleteGradeHandler)
 
-	r.Static("/uploads", "./uploads")
+	r.Static("/files", "./uploads")
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -154,19 +214,22 @@ func main() {
 // ===== USERS =====
 func createUserHandler(c *gin.Context) {
 	var user struct {
-		UID           string `json:"uid"`
-		Email         string `json:"email"`
-		Name          string `json:"name"`
-		Birthday      string `json:"birthday"`
-		Age           string `json:"age"`
-		Address       string `json:"address"`
-		MotherName    string `json:"motherName"`
-		FatherName    string `json:"fatherName"`
-		MotherOcc     string `json:"motherOcc"`
-		FatherOcc     string `json:"fatherOcc"`
-		MotherBday    string `json:"motherBday"`
-		FatherBday    string `json:"fatherBday"`
-		ContactNumber string `json:"contactNumber"`
+		UID           string   `json:"uid"`
+		Email         string   `json:"email"`
+		Password      string   `json:"password"`
+		Role          string   `json:"role"`
+		Groups        []string `json:"groups"`
+		Name          string   `json:"name"`
+		Birthday      string   `json:"birthday"`
+		Age           string   `json:"age"`
+		Address       string   `json:"address"`
+		MotherName    string   `json:"motherName"`
+		FatherName    string   `json:"fatherName"`
+		MotherOcc     string   `json:"motherOcc"`
+		FatherOcc     string   `json:"fatherOcc"`
+		MotherBday    string   `json:"motherBday"`
+		FatherBday    string   `json:"fatherBday"`
+		ContactNumber string   `json:"contactNumber"`
 	}
 
 	if err := c.BindJSON(&user); err != nil {
@@ -174,9 +237,31 @@ func createUserHandler(c *gin.Context) {
 		return
 	}
 
+	hashedPassword, err := hashPassword(user.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// role is never trusted from client JSON: every self-signup is a
+	// parent account. Granting "admin"/"teacher" requires an
+	// authenticated admin to make the request.
+	role := "parent"
+	if user.Role != "" && user.Role != "parent" {
+		caller := optionalUser(c)
+		if caller == nil || caller.Role != "admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only an admin can grant that role"})
+			return
+		}
+		role = user.Role
+	}
+
 	doc := bson.M{
 		"uid":           user.UID,
 		"email":         user.Email,
+		"password":      hashedPassword,
+		"role":          role,
+		"groups":        user.Groups,
 		"name":          user.Name,
 		"birthday":      user.Birthday,
 		"age":           user.Age,
@@ -191,7 +276,7 @@ func createUserHandler(c *gin.Context) {
 		"createdAt":     time.Now(),
 	}
 
-	_, err := usersCollection.InsertOne(context.Background(), doc)
+	_, err = usersCollection.InsertOne(context.Background(), doc)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 		return
@@ -200,21 +285,6 @@ func createUserHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "User created successfully"})
 }
 
-func loginHandler(c *gin.Context) {
-	var req map[string]string
-	if err := c.BindJSON(&req); err != nil {
-		c.String(http.StatusBadRequest, "Invalid JSON")
-		return
-	}
-	filter := bson.M{"email": req["email"], "password": req["password"]}
-	var user map[string]interface{}
-	if err := usersCollection.FindOne(context.Background(), filter).Decode(&user); err != nil {
-		c.String(http.StatusUnauthorized, "Invalid credentials")
-		return
-	}
-	c.JSON(http.StatusOK, user)
-}
-
 func getUserProfileHandler(c *gin.Context) {
 	var req struct {
 		UID string `json:"uid"`
@@ -224,7 +294,8 @@ func getUserProfileHandler(c *gin.Context) {
 		return
 	}
 	var user bson.M
-	if err := usersCollection.FindOne(context.Background(), bson.M{"uid": req.UID}).Decode(&user); err != nil {
+	findOpts := options.FindOne().SetProjection(bson.M{"password": 0})
+	if err := usersCollection.FindOne(context.Background(), bson.M{"uid": req.UID}, findOpts).Decode(&user); err != nil {
 		c.String(http.StatusNotFound, "User not found")
 		return
 	}
@@ -232,14 +303,14 @@ func getUserProfileHandler(c *gin.Context) {
 }
 
 func getUsersHandler(c *gin.Context) {
-	cursor, err := usersCollection.Find(context.Background(), bson.M{})
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to fetch users")
-		return
+	opts := parseListOptions(c)
+	opts.Projection = bson.M{"password": 0}
+	filter := bson.M{}
+	if opts.Query != "" {
+		filter["name"] = bson.M{"$regex": opts.Query, "$options": "i"}
 	}
 	var users []bson.M
-	cursor.All(context.Background(), &users)
-	c.JSON(http.StatusOK, users)
+	runListQuery(c, usersCollection, filter, opts, &users)
 }
 
 func deleteUserHandler(c *gin.Context) {
@@ -260,14 +331,9 @@ func deleteUserHandler(c *gin.Context) {
 
 // ===== POSTS =====
 func getPostsHandler(c *gin.Context) {
-	cursor, err := postsCollection.Find(context.Background(), bson.M{})
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to fetch posts")
-		return
-	}
+	opts := parseListOptions(c)
 	var posts []bson.M
-	cursor.All(context.Background(), &posts)
-	c.JSON(http.StatusOK, posts)
+	runListQuery(c, postsCollection, bson.M{}, opts, &posts)
 }
 
 func uploadPostHandler(c *gin.Context) {
@@ -306,14 +372,19 @@ func deletePostHandler(c *gin.Context) {
 
 // ===== CHAT =====
 func getMessagesHandler(c *gin.Context) {
-	cursor, err := messagesCollection.Find(context.Background(), bson.M{})
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to fetch messages")
-		return
+	opts := parseListOptions(c)
+	filter := bson.M{}
+	if senderID := c.Query("senderId"); senderID != "" {
+		filter["senderId"] = senderID
+	}
+	if receiverID := c.Query("receiverId"); receiverID != "" {
+		filter["receiverId"] = receiverID
+	}
+	if r := sinceUntil(c, "createdAt"); r != nil {
+		filter["createdAt"] = r["createdAt"]
 	}
 	var messages []bson.M
-	cursor.All(context.Background(), &messages)
-	c.JSON(http.StatusOK, messages)
+	runListQuery(c, messagesCollection, filter, opts, &messages)
 }
 
 func sendMessageHandler(c *gin.Context) {
@@ -330,12 +401,8 @@ func sendMessageHandler(c *gin.Context) {
 	}
 	senderID := fmt.Sprint(msg["senderId"])
 	receiverID := fmt.Sprint(msg["receiverId"])
-	if conn, ok := connectedUsers[senderID]; ok {
-		conn.Emit("receive_message", msg)
-	}
-	if conn, ok := connectedUsers[receiverID]; ok {
-		conn.Emit("receive_message", msg)
-	}
+	publishToUser(senderID, "receive_message", msg)
+	publishToUser(receiverID, "receive_message", msg)
 	c.JSON(http.StatusOK, msg)
 }
 
@@ -360,18 +427,28 @@ func addPaymentHandler(c *gin.Context) {
 		return
 	}
 	payment["_id"] = res.InsertedID
+
+	if amountSats, ok := payment["amountSats"].(float64); ok && amountSats > 0 {
+		paymentID := res.InsertedID.(primitive.ObjectID)
+		memo := fmt.Sprintf("Tuition payment %s", paymentID.Hex())
+		if err := addInvoiceToPayment(paymentID, int64(amountSats), memo); err != nil {
+			log.Println("⚠️ Failed to create Lightning invoice:", err)
+		} else {
+			payment["invoiceStatus"] = "pending"
+		}
+	}
+
 	c.JSON(http.StatusOK, payment)
 }
 
 func getPaymentsHandler(c *gin.Context) {
-	cursor, err := paymentCollection.Find(context.Background(), bson.M{})
-	if err != nil {
-		c.String(http.StatusInternalServerError, "Failed to fetch payments")
-		return
+	opts := parseListOptions(c)
+	filter := bson.M{}
+	if r := sinceUntil(c, "createdAt"); r != nil {
+		filter["createdAt"] = r["createdAt"]
 	}
 	var payments []bson.M
-	cursor.All(context.Background(), &payments)
-	c.JSON(http.StatusOK, payments)
+	runListQuery(c, paymentCollection, filter, opts, &payments)
 }
 
 // ✅ FIXED: real-time and DB update for month
@@ -406,10 +483,8 @@ func updatePaymentMonthHandler(c *gin.Context) {
 		return
 	}
 
-	// 🔁 Emit real-time event to all connected sockets
-	for _, conn := range connectedUsers {
-		conn.Emit("payment_update", updated)
-	}
+	// 🔁 Emit real-time event to all connected sockets, across instances
+	publishPaymentUpdate(updated)
 
 	c.JSON(http.StatusOK, updated)
 }
@@ -422,17 +497,60 @@ func uploadModuleHandler(c *gin.Context) {
 		c.String(http.StatusBadRequest, "File not provided")
 		return
 	}
-	if _, err := os.Stat("./uploads"); os.IsNotExist(err) {
-		os.Mkdir("./uploads", os.ModePerm)
+
+	opened, err := file.Open()
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to read file")
+		return
+	}
+	defer opened.Close()
+
+	sniffBuf := make([]byte, 512)
+	n, _ := opened.Read(sniffBuf)
+	contentType := http.DetectContentType(sniffBuf[:n])
+	if !whitelistedMimeTypes[contentType] {
+		c.String(http.StatusUnsupportedMediaType, "File type %q is not allowed", contentType)
+		return
+	}
+	if _, err := opened.Seek(0, io.SeekStart); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to read file")
+		return
+	}
+
+	scanned, err := scanForVirus(opened)
+	if err != nil {
+		c.String(http.StatusUnprocessableEntity, "Upload rejected: %v", err)
+		return
 	}
-	filename := filepath.Base(file.Filename)
-	savePath := "./uploads/" + filename
-	if err := c.SaveUploadedFile(file, savePath); err != nil {
+
+	filename := primitive.NewObjectID().Hex() + "-" + filepath.Base(file.Filename)
+	if err := blobStore.Save(context.Background(), filename, scanned); err != nil {
 		c.String(http.StatusInternalServerError, "Failed to save file")
 		return
 	}
-	publicUrl := fmt.Sprintf("%s/uploads/%s", BACKEND_URL, filename)
-	module := Module{Title: title, FileUrl: publicUrl, CreatedAt: time.Now()}
+	publicUrl, err := blobStore.SignedURL(context.Background(), filename, 24*time.Hour)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to sign file URL")
+		return
+	}
+
+	promo, _ := strconv.Atoi(c.PostForm("promo"))
+	shown := c.PostForm("shown") != "false"
+	descriptionRaw := c.PostForm("description")
+
+	module := Module{
+		Title:             title,
+		FileUrl:           publicUrl,
+		StorageKey:        filename,
+		Promo:             promo,
+		Group:             c.PostForm("group"),
+		StartAvailability: parseAvailability(c.PostForm("startAvailability"), time.Now()),
+		EndAvailability:   parseAvailability(c.PostForm("endAvailability"), time.Time{}),
+		Shown:             shown,
+		DescriptionRaw:    descriptionRaw,
+		DescriptionHTML:   string(blackfriday.Run([]byte(descriptionRaw))),
+		CreatedAt:         time.Now(),
+	}
 	res, err := modulesCollection.InsertOne(context.Background(), module)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "DB insert failed")
@@ -442,15 +560,137 @@ func uploadModuleHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, module)
 }
 
+func parseAvailability(value string, fallback time.Time) time.Time {
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fallback
+	}
+	return parsed
+}
+
+// getModulesHandler returns only what the caller is entitled to see:
+// admins get every module (optionally filtered by ?promo=&group=),
+// sorted by promo descending, while everyone else only sees modules
+// that are shown, within their availability window, and either
+// ungrouped or matching one of the caller's groups.
 func getModulesHandler(c *gin.Context) {
-	cursor, err := modulesCollection.Find(context.Background(), bson.M{})
+	user := optionalUser(c)
+	listOpts := parseListOptions(c)
+
+	filter := bson.M{}
+	sort := bson.D{{Key: "createdAt", Value: -1}}
+	if user != nil && user.Role == "admin" {
+		if promo := c.Query("promo"); promo != "" {
+			if n, err := strconv.Atoi(promo); err == nil {
+				filter["promo"] = n
+			}
+		}
+		if group := c.Query("group"); group != "" {
+			filter["group"] = group
+		}
+		sort = bson.D{{Key: "promo", Value: -1}}
+	} else {
+		now := time.Now()
+		groupFilter := bson.A{bson.M{"group": ""}}
+		if user != nil {
+			groupFilter = append(groupFilter, bson.M{"group": bson.M{"$in": user.Groups}})
+		}
+		// EndAvailability's zero value means "no end" — a module with
+		// one set only stays visible while now is still inside the window.
+		endFilter := bson.A{
+			bson.M{"endAvailability": time.Time{}},
+			bson.M{"endAvailability": bson.M{"$gte": now}},
+		}
+		filter["shown"] = true
+		filter["startAvailability"] = bson.M{"$lte": now}
+		filter["$and"] = bson.A{
+			bson.M{"$or": groupFilter},
+			bson.M{"$or": endFilter},
+		}
+	}
+
+	opts := options.Find().SetLimit(listOpts.Limit).SetSkip(listOpts.Offset).SetSort(sort)
+	total, err := modulesCollection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Failed to count modules")
+		return
+	}
+	cursor, err := modulesCollection.Find(context.Background(), filter, opts)
 	if err != nil {
 		c.String(http.StatusInternalServerError, "Failed to fetch modules")
 		return
 	}
 	var modules []Module
-	cursor.All(context.Background(), &modules)
-	c.JSON(http.StatusOK, modules)
+	if err := cursor.All(context.Background(), &modules); err != nil {
+		c.String(http.StatusInternalServerError, "Failed to decode modules")
+		return
+	}
+
+	nextOffset := listOpts.Offset + listOpts.Limit
+	if nextOffset >= total {
+		nextOffset = 0
+	}
+	c.JSON(http.StatusOK, listEnvelope{Items: modules, Total: total, NextOffset: nextOffset})
+}
+
+// updateModuleHandler lets a teacher/admin toggle visibility or shift
+// the availability window without re-uploading the file.
+func updateModuleHandler(c *gin.Context) {
+	id := c.Param("id")
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid module ID"})
+		return
+	}
+
+	var req struct {
+		Shown             *bool   `json:"shown"`
+		Group             *string `json:"group"`
+		Promo             *int    `json:"promo"`
+		StartAvailability *string `json:"startAvailability"`
+		EndAvailability   *string `json:"endAvailability"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	set := bson.M{}
+	if req.Shown != nil {
+		set["shown"] = *req.Shown
+	}
+	if req.Group != nil {
+		set["group"] = *req.Group
+	}
+	if req.Promo != nil {
+		set["promo"] = *req.Promo
+	}
+	if req.StartAvailability != nil {
+		set["startAvailability"] = parseAvailability(*req.StartAvailability, time.Time{})
+	}
+	if req.EndAvailability != nil {
+		set["endAvailability"] = parseAvailability(*req.EndAvailability, time.Time{})
+	}
+	if len(set) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+
+	_, err = modulesCollection.UpdateOne(context.Background(), bson.M{"_id": objID}, bson.M{"$set": set})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update module"})
+		return
+	}
+
+	var updated Module
+	if err := modulesCollection.FindOne(context.Background(), bson.M{"_id": objID}).Decode(&updated); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated module"})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
 }
 
 func deleteModuleHandler(c *gin.Context) {
@@ -469,8 +709,10 @@ func deleteModuleHandler(c *gin.Context) {
 		c.String(http.StatusNotFound, "Module not found")
 		return
 	}
-	if filePath, ok := module["fileUrl"].(string); ok {
-		_ = os.Remove(filePath)
+	if key, ok := module["storageKey"].(string); ok && key != "" {
+		if err := blobStore.Delete(context.Background(), key); err != nil {
+			log.Println("⚠️ Failed to delete blob:", err)
+		}
 	}
 	_, err = modulesCollection.DeleteOne(context.Background(), bson.M{"_id": objID})
 	if err != nil {
@@ -503,19 +745,13 @@ func uploadGradeHandler(c *gin.Context) {
 }
 
 func getGradesHandler(c *gin.Context) {
-	userId := c.Query("userId")
+	opts := parseListOptions(c)
 	filter := bson.M{}
-	if userId != "" {
+	if userId := c.Query("userId"); userId != "" {
 		filter["userId"] = userId
 	}
-	cursor, err := gradesCollection.Find(context.Background(), filter)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch grades"})
-		return
-	}
 	var grades []bson.M
-	cursor.All(context.Background(), &grades)
-	c.JSON(http.StatusOK, grades)
+	runListQuery(c, gradesCollection, filter, opts, &grades)
 }
 
 func deleteGradeHandler(c *gin.Context) {
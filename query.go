@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxListLimit caps how many documents any single list endpoint can
+// return in one response, regardless of what the caller asks for.
+const maxListLimit = 1000
+
+// defaultListLimit is used when the caller doesn't pass ?limit=.
+const defaultListLimit = 50
+
+// listEnvelope is the shape every paginated list endpoint responds
+// with, so clients can page consistently across resources.
+type listEnvelope struct {
+	Items      interface{} `json:"items"`
+	Total      int64       `json:"total"`
+	NextOffset int64       `json:"next_offset"`
+}
+
+// ListOptions captures the common ?limit=&offset=&sort_column=&sort_order=&q=
+// query params shared by every list endpoint, translated straight into
+// Mongo's FindOptions.
+type ListOptions struct {
+	Limit      int64
+	Offset     int64
+	SortColumn string
+	SortOrder  int
+	Query      string
+	Projection bson.M
+}
+
+// parseListOptions reads the shared pagination/sort params off the
+// request, defaulting sort to createdAt desc (newest first) and
+// clamping limit to maxListLimit.
+func parseListOptions(c *gin.Context) ListOptions {
+	limit := int64(defaultListLimit)
+	if raw := c.Query("limit"); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			limit = n
+		}
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	var offset int64
+	if raw := c.Query("offset"); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n > 0 {
+			offset = n
+		}
+	}
+
+	sortColumn := c.DefaultQuery("sort_column", "createdAt")
+	sortOrder := -1
+	if c.Query("sort_order") == "asc" {
+		sortOrder = 1
+	}
+
+	return ListOptions{
+		Limit:      limit,
+		Offset:     offset,
+		SortColumn: sortColumn,
+		SortOrder:  sortOrder,
+		Query:      c.Query("q"),
+	}
+}
+
+// RequireValidLimit rejects a non-positive ?limit= with a 400, mirroring
+// the validation pattern used elsewhere in this codebase (e.g. UID
+// required checks).
+func RequireValidLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if raw := c.Query("limit"); raw != "" {
+			if n, err := strconv.Atoi(raw); err != nil || n <= 0 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+func (o ListOptions) findOptions() *options.FindOptions {
+	opts := options.Find().
+		SetLimit(o.Limit).
+		SetSkip(o.Offset).
+		SetSort(bson.D{{Key: o.SortColumn, Value: o.SortOrder}})
+	if o.Projection != nil {
+		opts.SetProjection(o.Projection)
+	}
+	return opts
+}
+
+// runListQuery executes filter against collection with the given
+// ListOptions and writes a listEnvelope, decoding documents into the
+// type pointed to by out (e.g. &[]bson.M{} or &[]Module{}).
+func runListQuery(c *gin.Context, collection *mongo.Collection, filter bson.M, opts ListOptions, out interface{}) {
+	total, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count documents"})
+		return
+	}
+
+	cursor, err := collection.Find(context.Background(), filter, opts.findOptions())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch documents"})
+		return
+	}
+	if err := cursor.All(context.Background(), out); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode documents"})
+		return
+	}
+
+	nextOffset := opts.Offset + opts.Limit
+	if nextOffset >= total {
+		nextOffset = 0
+	}
+	c.JSON(http.StatusOK, listEnvelope{Items: out, Total: total, NextOffset: nextOffset})
+}
+
+// ensureIndexes creates the indexes the list/query endpoints rely on.
+// It's safe to call on every boot: the createIndexes command behind
+// Indexes().CreateOne is a no-op when an equivalent index already exists.
+func ensureIndexes(ctx context.Context) error {
+	_, err := messagesCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "senderId", Value: 1},
+			{Key: "receiverId", Value: 1},
+			{Key: "createdAt", Value: -1},
+		},
+	})
+	return err
+}
+
+// messagesCursorHandler serves append-only chat history with a
+// cursor (?before=<objectid>&limit=50) instead of offset pagination,
+// since offsets get expensive once a conversation is long.
+func messagesCursorHandler(c *gin.Context) {
+	limit := int64(50)
+	if raw := c.Query("limit"); raw != "" {
+		if n, err := strconv.ParseInt(raw, 10, 64); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	filter := bson.M{}
+	if senderID := c.Query("senderId"); senderID != "" {
+		filter["senderId"] = senderID
+	}
+	if receiverID := c.Query("receiverId"); receiverID != "" {
+		filter["receiverId"] = receiverID
+	}
+	if before := c.Query("before"); before != "" {
+		objID, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before cursor"})
+			return
+		}
+		filter["_id"] = bson.M{"$lt": objID}
+	}
+
+	opts := options.Find().SetLimit(limit).SetSort(bson.D{{Key: "_id", Value: -1}})
+	cursor, err := messagesCollection.Find(context.Background(), filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+	var messages []bson.M
+	if err := cursor.All(context.Background(), &messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode messages"})
+		return
+	}
+
+	var nextBefore string
+	if len(messages) == int(limit) {
+		if id, ok := messages[len(messages)-1]["_id"].(primitive.ObjectID); ok {
+			nextBefore = id.Hex()
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"items": messages, "next_before": nextBefore})
+}
+
+// sinceUntil reads a pair of RFC3339 ?since=&until= params into a Mongo
+// range filter on field, omitting bounds that weren't supplied.
+func sinceUntil(c *gin.Context, field string) bson.M {
+	rangeFilter := bson.M{}
+	if since := c.Query("since"); since != "" {
+		if t, err := time.Parse(time.RFC3339, since); err == nil {
+			rangeFilter["$gte"] = t
+		}
+	}
+	if until := c.Query("until"); until != "" {
+		if t, err := time.Parse(time.RFC3339, until); err == nil {
+			rangeFilter["$lte"] = t
+		}
+	}
+	if len(rangeFilter) == 0 {
+		return nil
+	}
+	return bson.M{field: rangeFilter}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	socketio "github.com/googollee/go-socket.io"
+	"github.com/redis/go-redis/v9"
+)
+
+// realtime fans out socket.io events across multiple server instances
+// via Redis pub/sub, since connectedUsers only covers sockets held by
+// this process. It also records in Redis which instance currently holds
+// a given UID's socket (presence keys with a TTL); the choice between
+// local delivery and publishing on Redis is made from connectedUsers
+// alone, and the presence keys are not read back.
+const (
+	redisPresenceTTL     = 60 * time.Second
+	redisUserChannelFmt  = "ws:user:%s"
+	redisPaymentsChannel = "ws:broadcast:payments"
+)
+
+var (
+	redisClient *redis.Client
+	instanceID  = os.Getenv("RAILWAY_REPLICA_ID")
+)
+
+type realtimeEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+func initRealtime() {
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		log.Println("⚠️ REDIS_URL not set, falling back to single-instance realtime")
+		return
+	}
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		log.Fatal("❌ Invalid REDIS_URL:", err)
+	}
+	redisClient = redis.NewClient(opts)
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		log.Fatal("❌ Redis ping failed:", err)
+	}
+	log.Println("✅ Redis connected successfully")
+
+	if instanceID == "" {
+		instanceID = "local"
+	}
+
+	go subscribeUserChannel()
+	go subscribePaymentsChannel()
+}
+
+// registerPresence records that this instance is holding uid's socket,
+// refreshed on every register event and expiring via TTL if the
+// instance disappears without a clean disconnect.
+func registerPresence(uid string) {
+	if redisClient == nil {
+		return
+	}
+	ctx := context.Background()
+	if err := redisClient.Set(ctx, presenceKey(uid), instanceID, redisPresenceTTL).Err(); err != nil {
+		log.Println("⚠️ Failed to register presence:", err)
+	}
+}
+
+func refreshPresence(uid string) {
+	if redisClient == nil {
+		return
+	}
+	redisClient.Expire(context.Background(), presenceKey(uid), redisPresenceTTL)
+}
+
+func clearPresence(uid string) {
+	if redisClient == nil {
+		return
+	}
+	redisClient.Del(context.Background(), presenceKey(uid))
+}
+
+func presenceKey(uid string) string {
+	return "presence:" + uid
+}
+
+// setConnectedUser, deleteConnectedUserBySocketID, getConnectedUser and
+// forEachConnectedUser are the only places allowed to touch
+// connectedUsers directly; every other access goes through them so the
+// map is never read or written outside connectedUsersMu's protection.
+// This matters here specifically because subscribeUserChannel and
+// subscribePaymentsChannel read the map continuously from their own
+// goroutines while HTTP handlers and socket.io callbacks mutate it
+// concurrently.
+func setConnectedUser(uid string, conn socketio.Conn) {
+	connectedUsersMu.Lock()
+	connectedUsers[uid] = conn
+	connectedUsersMu.Unlock()
+}
+
+func deleteConnectedUserBySocketID(socketID string) (string, bool) {
+	connectedUsersMu.Lock()
+	defer connectedUsersMu.Unlock()
+	for uid, conn := range connectedUsers {
+		if conn.ID() == socketID {
+			delete(connectedUsers, uid)
+			return uid, true
+		}
+	}
+	return "", false
+}
+
+func getConnectedUser(uid string) (socketio.Conn, bool) {
+	connectedUsersMu.RLock()
+	defer connectedUsersMu.RUnlock()
+	conn, ok := connectedUsers[uid]
+	return conn, ok
+}
+
+func forEachConnectedUser(fn func(uid string, conn socketio.Conn)) {
+	connectedUsersMu.RLock()
+	defer connectedUsersMu.RUnlock()
+	for uid, conn := range connectedUsers {
+		fn(uid, conn)
+	}
+}
+
+// publishToUser delivers an event to uid's socket, either directly if
+// this instance holds it or via Redis pub/sub otherwise.
+func publishToUser(uid, event string, data interface{}) {
+	if conn, ok := getConnectedUser(uid); ok {
+		conn.Emit(event, data)
+		return
+	}
+	if redisClient == nil {
+		return
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Println("⚠️ Failed to marshal realtime event:", err)
+		return
+	}
+	envelope, err := json.Marshal(realtimeEvent{Event: event, Data: payload})
+	if err != nil {
+		return
+	}
+	channel := redisChannelForUser(uid)
+	if err := redisClient.Publish(context.Background(), channel, envelope).Err(); err != nil {
+		log.Println("⚠️ Failed to publish realtime event:", err)
+	}
+}
+
+// publishPaymentUpdate fans a payment update out to every instance,
+// which then forwards it to any locally-held sockets. This instance's
+// own sockets are reached through its own subscription, so without
+// Redis the update is emitted to local sockets directly instead.
+func publishPaymentUpdate(payment interface{}) {
+	if redisClient == nil {
+		forEachConnectedUser(func(_ string, conn socketio.Conn) {
+			conn.Emit("payment_update", payment)
+		})
+		return
+	}
+	payload, err := json.Marshal(payment)
+	if err != nil {
+		return
+	}
+	envelope, err := json.Marshal(realtimeEvent{Event: "payment_update", Data: payload})
+	if err != nil {
+		return
+	}
+	if err := redisClient.Publish(context.Background(), redisPaymentsChannel, envelope).Err(); err != nil {
+		log.Println("⚠️ Failed to publish payment update:", err)
+	}
+}
+
+func redisChannelForUser(uid string) string {
+	return "ws:user:" + uid
+}
+
+// subscribeUserChannel listens on every per-user channel this instance
+// might need to forward for. Since subscribing to a pattern is cheaper
+// than one subscription per UID, we use PSubscribe against the family
+// of ws:user:* channels and only forward when we hold that UID locally.
+func subscribeUserChannel() {
+	pubsub := redisClient.PSubscribe(context.Background(), "ws:user:*")
+	defer pubsub.Close()
+	for msg := range pubsub.Channel() {
+		forwardIfLocal(msg.Channel, msg.Payload)
+	}
+}
+
+func subscribePaymentsChannel() {
+	pubsub := redisClient.Subscribe(context.Background(), redisPaymentsChannel)
+	defer pubsub.Close()
+	for msg := range pubsub.Channel() {
+		var event realtimeEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			continue
+		}
+		var payment interface{}
+		if err := json.Unmarshal(event.Data, &payment); err != nil {
+			continue
+		}
+		forEachConnectedUser(func(_ string, conn socketio.Conn) {
+			conn.Emit(event.Event, payment)
+		})
+	}
+}
+
+func forwardIfLocal(channel, payload string) {
+	uid := channel[len("ws:user:"):]
+	conn, ok := getConnectedUser(uid)
+	if !ok {
+		return
+	}
+	var event realtimeEvent
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return
+	}
+	var data interface{}
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return
+	}
+	conn.Emit(event.Event, data)
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// whitelistedMimeTypes mirrors what teachers are actually expected to
+// upload as module material; anything else is rejected up front.
+var whitelistedMimeTypes = map[string]bool{
+	"application/pdf":  true,
+	"image/png":        true,
+	"image/jpeg":       true,
+	"application/msword": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": true,
+	"application/zip": true,
+}
+
+// Blob is the storage backend modules (and any future uploads) are
+// saved to. LocalFS keeps the old behavior for local dev; S3Compatible
+// is what actually survives a Railway redeploy.
+type Blob interface {
+	Save(ctx context.Context, key string, r io.Reader) error
+	Delete(ctx context.Context, key string) error
+	SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error)
+}
+
+var blobStore Blob
+
+func initStorage() {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		blobStore = &LocalFS{dir: "./uploads"}
+		return
+	}
+	blobStore = newS3Compatible(bucket)
+}
+
+// LocalFS is the fallback used when no S3-compatible bucket is
+// configured (e.g. running locally without MinIO).
+type LocalFS struct {
+	dir string
+}
+
+func (l *LocalFS) Save(ctx context.Context, key string, r io.Reader) error {
+	if err := os.MkdirAll(l.dir, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.Create(filepath.Join(l.dir, key))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func (l *LocalFS) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(l.dir, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (l *LocalFS) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("%s/files/%s", BACKEND_URL, key), nil
+}
+
+// S3Compatible works against any S3-compatible endpoint (AWS S3 or a
+// self-hosted MinIO), configured entirely from env so swapping
+// providers doesn't need a code change.
+type S3Compatible struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3Compatible(bucket string) *S3Compatible {
+	endpoint := os.Getenv("S3_ENDPOINT")
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(),
+		awsconfig.WithRegion(os.Getenv("S3_REGION")),
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+			os.Getenv("S3_ACCESS_KEY"), os.Getenv("S3_SECRET_KEY"), "")),
+	)
+	if err != nil {
+		log.Fatal("❌ Failed to load S3 config:", err)
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = true
+	})
+	return &S3Compatible{client: client, bucket: bucket}
+}
+
+func (s *S3Compatible) Save(ctx context.Context, key string, r io.Reader) error {
+	uploader := manager.NewUploader(s.client)
+	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	})
+	return err
+}
+
+func (s *S3Compatible) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3Compatible) SignedURL(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	presigner := s3.NewPresignClient(s.client)
+	req, err := presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// scanForVirus streams r through a ClamAV TCP scanner using the clamd
+// INSTREAM protocol, when CLAMAV_ADDR is configured. It never buffers
+// the whole upload in memory: each 4096-byte frame is forwarded to
+// clamd as it's read. r must be seekable so the scan can rewind it to
+// the start for the caller once clamd clears it. If CLAMAV_ADDR isn't
+// configured, uploads aren't scanned (local dev).
+func scanForVirus(r io.ReadSeeker) (io.Reader, error) {
+	addr := os.Getenv("CLAMAV_ADDR")
+	if addr == "" {
+		return r, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("clamav unreachable: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, err
+	}
+
+	chunkSize := make([]byte, 4)
+	buf := make([]byte, 4096)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			putUint32BE(chunkSize, uint32(n))
+			if _, err := conn.Write(chunkSize); err != nil {
+				return nil, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+	putUint32BE(chunkSize, 0)
+	if _, err := conn.Write(chunkSize); err != nil {
+		return nil, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if reply != "stream: OK\x00" && reply != "stream: OK" {
+		return nil, fmt.Errorf("clamav rejected upload: %s", reply)
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func putUint32BE(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
+
+func containsFold(haystack, needle string) bool {
+	return len(haystack) >= len(needle) && indexFold(haystack, needle) >= 0
+}
+
+func indexFold(haystack, needle string) int {
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		if equalFold(haystack[i:i+len(needle)], needle) {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalFold(a, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		ca, cb := a[i], b[i]
+		if 'A' <= ca && ca <= 'Z' {
+			ca += 'a' - 'A'
+		}
+		if 'A' <= cb && cb <= 'Z' {
+			cb += 'a' - 'A'
+		}
+		if ca != cb {
+			return false
+		}
+	}
+	return true
+}
+
+// in-memory uploads in progress, keyed by upload id. A resumable
+// upload tracks how many bytes have been appended so far; the actual
+// bytes are staged to a temp file and only handed to blobStore once
+// the client reports it's done.
+type resumableUpload struct {
+	Filename string
+	TempPath string
+	Offset   int64
+}
+
+var (
+	resumableUploads   = make(map[string]*resumableUpload)
+	resumableUploadsMu sync.Mutex
+)
+
+// createUploadHandler starts a tus-style resumable upload and returns
+// an id the client PATCHes bytes to.
+func createUploadHandler(c *gin.Context) {
+	filename := filepath.Base(c.GetHeader("Upload-Filename"))
+	if filename == "." || filename == "/" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Filename header required"})
+		return
+	}
+	id := primitive.NewObjectID().Hex()
+	tempPath := filepath.Join(os.TempDir(), "upload-"+id)
+	f, err := os.Create(tempPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stage upload"})
+		return
+	}
+	f.Close()
+	resumableUploadsMu.Lock()
+	resumableUploads[id] = &resumableUpload{Filename: filename, TempPath: tempPath}
+	resumableUploadsMu.Unlock()
+	c.Header("Location", "/uploads/"+id)
+	c.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+// appendUploadHandler appends a Content-Range chunk to a pending
+// resumable upload, streaming straight to the temp file without
+// buffering the whole body in memory.
+func appendUploadHandler(c *gin.Context) {
+	id := c.Param("id")
+	resumableUploadsMu.Lock()
+	upload, ok := resumableUploads[id]
+	resumableUploadsMu.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+
+	var offset int64
+	var err error
+	if contentRange := c.GetHeader("Content-Range"); contentRange != "" {
+		_, err = fmt.Sscanf(contentRange, "bytes %d-", &offset)
+	} else {
+		offset, err = strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	}
+	if err != nil || offset != upload.Offset {
+		c.JSON(http.StatusConflict, gin.H{"error": "Offset mismatch"})
+		return
+	}
+
+	f, err := os.OpenFile(upload.TempPath, os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open staged upload"})
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to seek staged upload"})
+		return
+	}
+
+	written, err := io.Copy(f, c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to append chunk"})
+		return
+	}
+	upload.Offset += written
+
+	if c.GetHeader("Upload-Complete") == "true" {
+		if err := finalizeUpload(c, id, upload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Status(http.StatusNoContent)
+}
+
+// headUploadHandler reports how many bytes have landed so far, so a
+// client can resume after a dropped connection.
+func headUploadHandler(c *gin.Context) {
+	id := c.Param("id")
+	resumableUploadsMu.Lock()
+	upload, ok := resumableUploads[id]
+	resumableUploadsMu.Unlock()
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Status(http.StatusOK)
+}
+
+// finalizeUpload sniffs the content type, runs it through ClamAV if
+// configured, and hands the result to the configured Blob backend.
+func finalizeUpload(c *gin.Context, id string, upload *resumableUpload) error {
+	defer func() {
+		os.Remove(upload.TempPath)
+		resumableUploadsMu.Lock()
+		delete(resumableUploads, id)
+		resumableUploadsMu.Unlock()
+	}()
+
+	f, err := os.Open(upload.TempPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sniffBuf := make([]byte, 512)
+	n, _ := f.Read(sniffBuf)
+	contentType := http.DetectContentType(sniffBuf[:n])
+	if !whitelistedMimeTypes[contentType] {
+		return fmt.Errorf("content type %q is not allowed", contentType)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	scanned, err := scanForVirus(f)
+	if err != nil {
+		return err
+	}
+
+	key := id + "-" + upload.Filename
+	if err := blobStore.Save(context.Background(), key, scanned); err != nil {
+		return err
+	}
+
+	signedURL, err := blobStore.SignedURL(context.Background(), key, 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	c.JSON(http.StatusOK, gin.H{"key": key, "url": signedURL})
+	return nil
+}